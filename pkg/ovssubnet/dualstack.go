@@ -0,0 +1,105 @@
+package ovssubnet
+
+import (
+	"fmt"
+	"net"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/pkg/ovssubnet/api"
+)
+
+// subnetCIDRs returns every CIDR allocated to sub, preferring the
+// multi-family SubnetCIDRs list and falling back to the legacy single
+// SubnetCIDR field for HostSubnets written before dual-stack support.
+func subnetCIDRs(sub api.Subnet) []string {
+	if len(sub.SubnetCIDRs) > 0 {
+		return sub.SubnetCIDRs
+	}
+	if sub.SubnetCIDR != "" {
+		return []string{sub.SubnetCIDR}
+	}
+	return nil
+}
+
+// setSubnetCIDRs stores cidrs on sub in both the new and legacy fields so
+// HostSubnets remain readable by nodes that only understand SubnetCIDR.
+func setSubnetCIDRs(sub *api.Subnet, cidrs []string) {
+	sub.SubnetCIDRs = cidrs
+	sub.SubnetCIDR = ""
+	for _, cidr := range cidrs {
+		if !isIPv6CIDR(cidr) {
+			sub.SubnetCIDR = cidr
+			break
+		}
+	}
+	if sub.SubnetCIDR == "" && len(cidrs) > 0 {
+		sub.SubnetCIDR = cidrs[0]
+	}
+}
+
+func isIPv6CIDR(cidr string) bool {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	return ip.To4() == nil
+}
+
+// allocateNodeNetworks asks every configured per-family IPAM backend
+// (IPv4, and IPv6 when the cluster network has a v6 range) for a CIDR
+// for nodeName, returning the full list to store on its HostSubnet. On
+// failure any CIDR already allocated in this call is released.
+func (oc *OvsController) allocateNodeNetworks(nodeName string) ([]string, error) {
+	cidrs := make([]string, 0, 2)
+
+	sn, err := oc.ipamV4.Allocate(nodeName, "")
+	if err != nil {
+		return nil, fmt.Errorf("error allocating IPv4 subnet for node %s: %v", nodeName, err)
+	}
+	cidrs = append(cidrs, sn.String())
+
+	if oc.ipamV6 != nil {
+		sn6, err := oc.ipamV6.Allocate(nodeName, "")
+		if err != nil {
+			oc.ipamV4.Release(sn)
+			return nil, fmt.Errorf("error allocating IPv6 subnet for node %s: %v", nodeName, err)
+		}
+		cidrs = append(cidrs, sn6.String())
+	}
+	return cidrs, nil
+}
+
+// releaseNodeNetworks returns every CIDR in cidrs to the IPAM backend
+// for its address family.
+func (oc *OvsController) releaseNodeNetworks(cidrs []string) {
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Errorf("Error parsing subnet CIDR %s for release: %v", cidr, err)
+			continue
+		}
+		if isIPv6CIDR(cidr) && oc.ipamV6 != nil {
+			oc.ipamV6.Release(ipnet)
+		} else {
+			oc.ipamV4.Release(ipnet)
+		}
+	}
+}
+
+// validateNodeIPFamily checks that nodeIP belongs to an address family
+// this cluster is actually configured for, so a v6 node can't be handed
+// a HostSubnet when there's no v6 cluster network to route it.
+func (oc *OvsController) validateNodeIPFamily(nodeIP string) error {
+	ip := net.ParseIP(nodeIP)
+	if ip == nil {
+		return fmt.Errorf("invalid node IP %q", nodeIP)
+	}
+	if ip.To4() != nil {
+		return nil
+	}
+	if oc.ipamV6 != nil {
+		return nil
+	}
+	return fmt.Errorf("node IP %q is IPv6 but no IPv6 cluster network is configured", nodeIP)
+}
@@ -0,0 +1,65 @@
+package ovssubnet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/pkg/ovssubnet/api"
+)
+
+func TestSetSubnetCIDRsAndSubnetCIDRsRoundTrip(t *testing.T) {
+	sub := &api.Subnet{}
+	setSubnetCIDRs(sub, []string{"10.1.2.0/24", "fd02::/48"})
+
+	if got := subnetCIDRs(*sub); !reflect.DeepEqual(got, []string{"10.1.2.0/24", "fd02::/48"}) {
+		t.Fatalf("subnetCIDRs round trip = %v, want %v", got, []string{"10.1.2.0/24", "fd02::/48"})
+	}
+	if sub.SubnetCIDR != "10.1.2.0/24" {
+		t.Fatalf("expected legacy SubnetCIDR to mirror the v4 entry, got %q", sub.SubnetCIDR)
+	}
+}
+
+func TestSetSubnetCIDRsLegacyFieldPrefersIPv4(t *testing.T) {
+	sub := &api.Subnet{}
+	setSubnetCIDRs(sub, []string{"fd02::/48", "10.1.2.0/24"})
+
+	if sub.SubnetCIDR != "10.1.2.0/24" {
+		t.Fatalf("expected legacy SubnetCIDR to prefer the v4 entry regardless of order, got %q", sub.SubnetCIDR)
+	}
+}
+
+func TestSetSubnetCIDRsLegacyFieldFallsBackToV6Only(t *testing.T) {
+	sub := &api.Subnet{}
+	setSubnetCIDRs(sub, []string{"fd02::/48"})
+
+	if sub.SubnetCIDR != "fd02::/48" {
+		t.Fatalf("expected legacy SubnetCIDR to fall back to the only (v6) entry, got %q", sub.SubnetCIDR)
+	}
+}
+
+func TestSubnetCIDRsFallsBackToLegacyField(t *testing.T) {
+	sub := api.Subnet{SubnetCIDR: "10.1.2.0/24"}
+
+	if got := subnetCIDRs(sub); !reflect.DeepEqual(got, []string{"10.1.2.0/24"}) {
+		t.Fatalf("subnetCIDRs legacy fallback = %v, want %v", got, []string{"10.1.2.0/24"})
+	}
+}
+
+func TestIsIPv6CIDR(t *testing.T) {
+	cases := []struct {
+		cidr string
+		want bool
+	}{
+		{"10.1.2.0/24", false},
+		{"192.168.0.0/16", false},
+		{"fd02::/48", true},
+		{"2001:db8::/32", true},
+		{"not-a-cidr", false},
+	}
+
+	for _, c := range cases {
+		if got := isIPv6CIDR(c.cidr); got != c.want {
+			t.Errorf("isIPv6CIDR(%q) = %v, want %v", c.cidr, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package ovssubnet
+
+import (
+	"os/exec"
+	"strconv"
+	"time"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/pkg/ovssubnet/api"
+)
+
+const (
+	gatewayProbeInterval = 5 * time.Second
+	gatewayProbeTimeout  = 2 * time.Second
+	// gatewayHoldDown is the minimum time between elections, so a
+	// candidate that flaps between ready/not-ready doesn't bounce
+	// external traffic back and forth.
+	gatewayHoldDown = 30 * time.Second
+)
+
+// healthCheck reports whether nodeIP is currently reachable.
+type healthCheck func(nodeIP string) bool
+
+// healthCheckFunc is the health check used to decide whether the active
+// egress gateway (or a candidate replacing it) is up. It is a var so it
+// can be swapped out for a different probe in tests or deployments that
+// can't shell out to ping.
+var healthCheckFunc healthCheck = pingHealthCheck
+
+// pingHealthCheck probes nodeIP with a single ICMP echo, the same
+// mechanism used elsewhere to decide node reachability.
+func pingHealthCheck(nodeIP string) bool {
+	cmd := exec.Command("ping", "-c", "1", "-W", strconv.Itoa(int(gatewayProbeTimeout/time.Second)), nodeIP)
+	return cmd.Run() == nil
+}
+
+// gatewayController elects and maintains the active egress gateway for
+// every HostSubnet that lists GatewayCandidates, failing over to another
+// candidate when the current GatewayNode stops responding to health
+// checks.
+type gatewayController struct {
+	oc *OvsController
+	// lastChange is keyed by node name (the owner of the HostSubnet, not
+	// the candidate) so the hold-down timer for one subnet's election
+	// doesn't block an unrelated subnet's failover.
+	lastChange map[string]time.Time
+}
+
+func newGatewayController(oc *OvsController) *gatewayController {
+	return &gatewayController{oc: oc, lastChange: make(map[string]time.Time)}
+}
+
+// watchGateways runs the election loop on the master until oc.sig fires.
+func (gc *gatewayController) watchGateways() {
+	ticker := time.NewTicker(gatewayProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			gc.reconcileAll()
+		case <-gc.oc.sig:
+			return
+		}
+	}
+}
+
+func (gc *gatewayController) reconcileAll() {
+	nodes, _, err := gc.oc.registry.GetNodes()
+	if err != nil {
+		log.Errorf("Error fetching nodes for egress gateway election: %v", err)
+		return
+	}
+	nodeIPs := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		nodeIPs[node.Name] = node.IP
+	}
+
+	for _, node := range nodes {
+		sub, err := gc.oc.registry.GetSubnet(node.Name)
+		if err != nil || len(sub.GatewayCandidates) == 0 {
+			continue
+		}
+		if err := gc.reconcile(node.Name, sub, nodeIPs); err != nil {
+			log.Errorf("Error reconciling egress gateway for node %s: %v", node.Name, err)
+		}
+	}
+}
+
+// inHoldDown reports whether an election for a subnet that last changed
+// gateway at lastChange (hasLastChange reports whether it ever has) is
+// still within gatewayHoldDown of now.
+func inHoldDown(hasLastChange bool, lastChange time.Time, now time.Time) bool {
+	return hasLastChange && now.Sub(lastChange) < gatewayHoldDown
+}
+
+// electGateway returns the first candidate in candidates that is known
+// and healthy, or "" if none are. Extracted as a pure function so the
+// candidate-iteration logic — the part of reconcile most likely to have
+// an off-by-one or an ordering bug — can be unit tested without a
+// registry.
+func electGateway(candidates []string, nodeIPs map[string]string, healthy healthCheck) string {
+	for _, candidate := range candidates {
+		ip, known := nodeIPs[candidate]
+		if !known || !healthy(ip) {
+			continue
+		}
+		return candidate
+	}
+	return ""
+}
+
+// reconcile re-elects sub's GatewayNode if the current one is unhealthy,
+// subject to gatewayHoldDown.
+func (gc *gatewayController) reconcile(nodeName string, sub *api.Subnet, nodeIPs map[string]string) error {
+	if sub.GatewayNode != "" && healthCheckFunc(nodeIPs[sub.GatewayNode]) {
+		return nil
+	}
+	last, hasLastChange := gc.lastChange[nodeName]
+	if inHoldDown(hasLastChange, last, time.Now()) {
+		return nil
+	}
+
+	elected := electGateway(sub.GatewayCandidates, nodeIPs, healthCheckFunc)
+	if elected == "" {
+		log.Warningf("No healthy egress gateway candidate available for node %s", nodeName)
+		return nil
+	}
+	if elected == sub.GatewayNode {
+		return nil
+	}
+
+	// The HostSubnet already exists, and the master isn't the only writer
+	// of it (the owning node renews its lease, and an IP change updates
+	// it too), so this goes through compare-and-swap rather than a blind
+	// CreateSubnet, which would both error on an existing key and risk
+	// clobbering a concurrent update.
+	if _, err := updateSubnetWithCAS(gc.oc.registry, nodeName, func(current *api.Subnet) error {
+		current.GatewayNode = elected
+		return nil
+	}); err != nil {
+		return err
+	}
+	log.Infof("Elected %s as egress gateway for node %s's subnet", elected, nodeName)
+	gc.lastChange[nodeName] = time.Now()
+	return nil
+}
+
+// applyGatewayElection reprograms flowController to steer external-bound
+// traffic for sub through the elected gateway node's tunnel endpoint, if
+// sub has an elected GatewayNode.
+func (oc *OvsController) applyGatewayElection(sub *api.Subnet) {
+	if sub.GatewayNode == "" {
+		return
+	}
+	gwSub, err := oc.registry.GetSubnet(sub.GatewayNode)
+	if err != nil {
+		log.Errorf("Error looking up elected egress gateway node %s: %v", sub.GatewayNode, err)
+		return
+	}
+	if err := oc.flowController.SetEgressGateway(gwSub.NodeIP); err != nil {
+		log.Errorf("Error programming egress gateway %s: %v", gwSub.NodeIP, err)
+	}
+}
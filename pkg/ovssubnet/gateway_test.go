@@ -0,0 +1,64 @@
+package ovssubnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInHoldDown(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	if inHoldDown(false, time.Time{}, now) {
+		t.Errorf("a subnet that has never elected a gateway must not be in hold-down")
+	}
+	if !inHoldDown(true, now.Add(-1*time.Second), now) {
+		t.Errorf("an election 1s ago should still be within gatewayHoldDown (%v)", gatewayHoldDown)
+	}
+	if inHoldDown(true, now.Add(-gatewayHoldDown-time.Second), now) {
+		t.Errorf("an election older than gatewayHoldDown should not be in hold-down")
+	}
+}
+
+func TestElectGatewayPicksFirstHealthyCandidate(t *testing.T) {
+	nodeIPs := map[string]string{"node-a": "10.0.0.1", "node-b": "10.0.0.2"}
+	healthy := func(ip string) bool { return ip == "10.0.0.2" }
+
+	if got := electGateway([]string{"node-a", "node-b"}, nodeIPs, healthy); got != "node-b" {
+		t.Errorf("expected the first healthy candidate (node-b), got %q", got)
+	}
+}
+
+func TestElectGatewaySkipsUnknownNodes(t *testing.T) {
+	nodeIPs := map[string]string{"node-b": "10.0.0.2"}
+	healthy := func(ip string) bool { return true }
+
+	if got := electGateway([]string{"node-a", "node-b"}, nodeIPs, healthy); got != "node-b" {
+		t.Errorf("expected node-a (not in nodeIPs) to be skipped in favor of node-b, got %q", got)
+	}
+}
+
+func TestElectGatewayReturnsEmptyWhenNoneHealthy(t *testing.T) {
+	nodeIPs := map[string]string{"node-a": "10.0.0.1"}
+	healthy := func(ip string) bool { return false }
+
+	if got := electGateway([]string{"node-a"}, nodeIPs, healthy); got != "" {
+		t.Errorf("expected no healthy candidate to elect \"\", got %q", got)
+	}
+}
+
+func TestInHoldDownIsPerSubnetIndependent(t *testing.T) {
+	now := time.Unix(1000, 0)
+	lastChange := map[string]time.Time{
+		"node-a": now.Add(-1 * time.Second), // just elected, still in hold-down
+	}
+
+	_, hasA := lastChange["node-a"]
+	if !inHoldDown(hasA, lastChange["node-a"], now) {
+		t.Errorf("node-a should be in hold-down right after its own election")
+	}
+
+	_, hasB := lastChange["node-b"]
+	if inHoldDown(hasB, lastChange["node-b"], now) {
+		t.Errorf("node-b, which never elected a gateway, must not be blocked by node-a's hold-down")
+	}
+}
@@ -0,0 +1,353 @@
+package ovssubnet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/openshift/openshift-sdn/pkg/netutils"
+)
+
+// IPAM is the interface node subnets are allocated through. Swapping
+// implementations changes how CIDRs are handed out without touching
+// addNode/deleteNode.
+type IPAM interface {
+	// Allocate returns a CIDR for nodeName. hint, when non-empty, is a
+	// CIDR the caller would prefer (e.g. one it previously held); an
+	// implementation may ignore it.
+	Allocate(nodeName, hint string) (*net.IPNet, error)
+	// Release returns ipnet to the pool of available CIDRs.
+	Release(ipnet *net.IPNet) error
+	// List returns every CIDR currently considered allocated.
+	List() ([]*net.IPNet, error)
+}
+
+// newIPAM builds the configured IPAM backend for the cluster network cn
+// (host subnet length hsl). oc.ipamBackend selects between the plain
+// in-memory allocator (the default), which is seeded from subrange (the
+// CIDRs already in use, found by scanning every existing HostSubnet),
+// and an etcd-backed one, which ignores subrange entirely and instead
+// seeds itself from its own persisted state so a master restart doesn't
+// need that scan. oc.reservedSubnetFile, if set, layers static
+// node->CIDR pins on top of whichever backend was chosen.
+func newIPAM(oc *OvsController, cn string, hsl uint, subrange []string) (IPAM, error) {
+	var backend IPAM
+	switch oc.ipamBackend {
+	case "etcd":
+		etcdBackend, err := newEtcdIPAM(oc.registry, cn, hsl)
+		if err != nil {
+			return nil, err
+		}
+		backend = etcdBackend
+	default:
+		alloc, err := netutils.NewSubnetAllocator(cn, hsl, subrange)
+		if err != nil {
+			return nil, err
+		}
+		backend = newInMemoryIPAM(alloc, subrange)
+	}
+
+	if oc.reservedSubnetFile == "" {
+		return backend, nil
+	}
+	reservations, err := loadReservedNodeCIDRs(oc.reservedSubnetFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading reserved subnet file %s: %v", oc.reservedSubnetFile, err)
+	}
+	return newReservedIPAM(reservations, backend), nil
+}
+
+// inMemoryIPAM is the original allocator: a bitmap held only in process
+// memory, seeded at startup by re-scanning every existing HostSubnet.
+type inMemoryIPAM struct {
+	lock      sync.Mutex
+	alloc     *netutils.SubnetAllocator
+	allocated map[string]*net.IPNet
+}
+
+func newInMemoryIPAM(alloc *netutils.SubnetAllocator, subrange []string) IPAM {
+	allocated := make(map[string]*net.IPNet, len(subrange))
+	for _, cidr := range subrange {
+		if _, ipnet, err := net.ParseCIDR(cidr); err == nil {
+			allocated[ipnet.String()] = ipnet
+		}
+	}
+	return &inMemoryIPAM{alloc: alloc, allocated: allocated}
+}
+
+func (i *inMemoryIPAM) Allocate(nodeName, hint string) (*net.IPNet, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	sn, err := i.alloc.GetNetwork()
+	if err != nil {
+		return nil, err
+	}
+	i.allocated[sn.String()] = sn
+	return sn, nil
+}
+
+func (i *inMemoryIPAM) Release(ipnet *net.IPNet) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.alloc.ReleaseNetwork(ipnet)
+	delete(i.allocated, ipnet.String())
+	return nil
+}
+
+func (i *inMemoryIPAM) List() ([]*net.IPNet, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	nets := make([]*net.IPNet, 0, len(i.allocated))
+	for _, n := range i.allocated {
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ipamStateKey is the dedicated etcd key etcdIPAM persists its allocated
+// set under, so a restarted master can load it in one read instead of
+// re-scanning every node's HostSubnet to rebuild the bitmap.
+const ipamStateKey = "ipam-allocated-cidrs"
+
+// maxIPAMCASRetries bounds how many times Allocate/Release will re-read
+// and retry against a concurrently-updated ipamStateKey before giving up.
+const maxIPAMCASRetries = 5
+
+// ipamStateStore is the slice of osdn.Registry that etcdIPAM needs.
+// CompareAndSwapIPAMState must only apply newValue if the key's current
+// value is still prevValue (ok == false otherwise), giving etcdIPAM real
+// optimistic concurrency instead of a racy read-modify-write.
+type ipamStateStore interface {
+	GetIPAMState(key string) (string, error)
+	CompareAndSwapIPAMState(key, prevValue, newValue string) (bool, error)
+}
+
+// etcdIPAM persists its allocated set to etcd under ipamStateKey via
+// compare-and-swap, so two masters racing to allocate a subnet can't both
+// write the same CIDR as allocated, and a restarted master recovers its
+// state in one read instead of re-scanning existing HostSubnets.
+type etcdIPAM struct {
+	lock      sync.Mutex
+	store     ipamStateStore
+	alloc     *netutils.SubnetAllocator
+	allocated map[string]bool
+}
+
+func newEtcdIPAM(store ipamStateStore, cn string, hsl uint) (IPAM, error) {
+	state, err := store.GetIPAMState(ipamStateKey)
+	if err != nil {
+		return nil, err
+	}
+	allocated := parseIPAMState(state)
+
+	cidrs := make([]string, 0, len(allocated))
+	for cidr := range allocated {
+		cidrs = append(cidrs, cidr)
+	}
+	alloc, err := netutils.NewSubnetAllocator(cn, hsl, cidrs)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdIPAM{store: store, alloc: alloc, allocated: allocated}, nil
+}
+
+func parseIPAMState(state string) map[string]bool {
+	allocated := make(map[string]bool)
+	for _, cidr := range strings.Split(state, ",") {
+		if cidr != "" {
+			allocated[cidr] = true
+		}
+	}
+	return allocated
+}
+
+func serializeIPAMState(allocated map[string]bool) string {
+	cidrs := make([]string, 0, len(allocated))
+	for cidr := range allocated {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+	return strings.Join(cidrs, ",")
+}
+
+// updateWithCAS applies mutate to a copy of e.allocated and persists the
+// result with compare-and-swap, retrying against the latest remote state
+// on conflict (i.e. another master wrote in between) up to
+// maxIPAMCASRetries times.
+func (e *etcdIPAM) updateWithCAS(mutate func(allocated map[string]bool)) error {
+	for attempt := 0; attempt < maxIPAMCASRetries; attempt++ {
+		e.lock.Lock()
+		prevState := serializeIPAMState(e.allocated)
+		working := make(map[string]bool, len(e.allocated))
+		for cidr := range e.allocated {
+			working[cidr] = true
+		}
+		e.lock.Unlock()
+
+		mutate(working)
+		newState := serializeIPAMState(working)
+
+		ok, err := e.store.CompareAndSwapIPAMState(ipamStateKey, prevState, newState)
+		if err != nil {
+			return err
+		}
+		if ok {
+			e.lock.Lock()
+			e.allocated = working
+			e.lock.Unlock()
+			return nil
+		}
+
+		// Another master updated ipamStateKey concurrently; re-read and
+		// retry the mutation against its current value.
+		current, err := e.store.GetIPAMState(ipamStateKey)
+		if err != nil {
+			return err
+		}
+		e.lock.Lock()
+		e.allocated = parseIPAMState(current)
+		e.lock.Unlock()
+	}
+	return fmt.Errorf("exceeded %d retries reconciling IPAM state with a concurrent writer", maxIPAMCASRetries)
+}
+
+func (e *etcdIPAM) Allocate(nodeName, hint string) (*net.IPNet, error) {
+	for {
+		e.lock.Lock()
+		sn, err := e.alloc.GetNetwork()
+		e.lock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		cidr := sn.String()
+		taken := false
+		if err := e.updateWithCAS(func(allocated map[string]bool) {
+			taken = allocated[cidr]
+			allocated[cidr] = true
+		}); err != nil {
+			e.lock.Lock()
+			e.alloc.ReleaseNetwork(sn)
+			e.lock.Unlock()
+			return nil, fmt.Errorf("error persisting IPAM state for node %s: %v", nodeName, err)
+		}
+		if !taken {
+			return sn, nil
+		}
+		// Another master already persisted cidr; leave it marked in our
+		// bitmap and try the next free one.
+	}
+}
+
+func (e *etcdIPAM) Release(ipnet *net.IPNet) error {
+	e.lock.Lock()
+	e.alloc.ReleaseNetwork(ipnet)
+	e.lock.Unlock()
+
+	cidr := ipnet.String()
+	return e.updateWithCAS(func(allocated map[string]bool) {
+		delete(allocated, cidr)
+	})
+}
+
+func (e *etcdIPAM) List() ([]*net.IPNet, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	nets := make([]*net.IPNet, 0, len(e.allocated))
+	for cidr := range e.allocated {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+// reservedIPAM serves statically pinned CIDRs for specific nodes and
+// falls back to another IPAM for any node without a reservation. This
+// gives operators deterministic assignments for gateway/storage nodes.
+type reservedIPAM struct {
+	lock         sync.Mutex
+	reservations map[string]string // nodeName -> CIDR
+	used         map[string]bool
+	fallback     IPAM
+}
+
+func newReservedIPAM(reservations map[string]string, fallback IPAM) IPAM {
+	return &reservedIPAM{
+		reservations: reservations,
+		used:         make(map[string]bool),
+		fallback:     fallback,
+	}
+}
+
+func (r *reservedIPAM) Allocate(nodeName, hint string) (*net.IPNet, error) {
+	r.lock.Lock()
+	cidr, reserved := r.reservations[nodeName]
+	r.lock.Unlock()
+	if !reserved {
+		return r.fallback.Allocate(nodeName, hint)
+	}
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reserved CIDR %q for node %s: %v", cidr, nodeName, err)
+	}
+	r.lock.Lock()
+	r.used[cidr] = true
+	r.lock.Unlock()
+	return ipnet, nil
+}
+
+func (r *reservedIPAM) Release(ipnet *net.IPNet) error {
+	r.lock.Lock()
+	cidr := ipnet.String()
+	_, wasReserved := r.used[cidr]
+	delete(r.used, cidr)
+	r.lock.Unlock()
+	if wasReserved {
+		return nil
+	}
+	return r.fallback.Release(ipnet)
+}
+
+func (r *reservedIPAM) List() ([]*net.IPNet, error) {
+	nets, err := r.fallback.List()
+	if err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for cidr := range r.used {
+		if _, ipnet, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, ipnet)
+		}
+	}
+	return nets, nil
+}
+
+// loadReservedNodeCIDRs reads static node->CIDR pins from a file of
+// "nodeName=cidr" lines (one entry per reserved node, '#' for comments),
+// the on-disk form of the reserved-node ConfigMap operators can mount in.
+func loadReservedNodeCIDRs(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reservations := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed reserved-subnet entry %q", line)
+		}
+		reservations[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return reservations, nil
+}
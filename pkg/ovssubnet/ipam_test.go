@@ -0,0 +1,195 @@
+package ovssubnet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+// fakeIPAM is a minimal in-memory IPAM fallback for exercising reservedIPAM
+// without pulling in netutils.SubnetAllocator.
+type fakeIPAM struct {
+	allocated map[string]*net.IPNet
+	nextErr   error
+}
+
+func newFakeIPAM() *fakeIPAM {
+	return &fakeIPAM{allocated: make(map[string]*net.IPNet)}
+}
+
+func (f *fakeIPAM) Allocate(nodeName, hint string) (*net.IPNet, error) {
+	if f.nextErr != nil {
+		return nil, f.nextErr
+	}
+	_, ipnet, _ := net.ParseCIDR(fmt.Sprintf("10.0.%d.0/24", len(f.allocated)))
+	f.allocated[ipnet.String()] = ipnet
+	return ipnet, nil
+}
+
+func (f *fakeIPAM) Release(ipnet *net.IPNet) error {
+	delete(f.allocated, ipnet.String())
+	return nil
+}
+
+func (f *fakeIPAM) List() ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(f.allocated))
+	for _, n := range f.allocated {
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func TestReservedIPAMUsesReservationWhenPresent(t *testing.T) {
+	fallback := newFakeIPAM()
+	r := newReservedIPAM(map[string]string{"node-a": "10.1.1.0/24"}, fallback)
+
+	ipnet, err := r.Allocate("node-a", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ipnet.String() != "10.1.1.0/24" {
+		t.Fatalf("expected reserved CIDR 10.1.1.0/24, got %s", ipnet.String())
+	}
+	if len(fallback.allocated) != 0 {
+		t.Fatalf("expected fallback not to be consulted for a reserved node")
+	}
+}
+
+func TestReservedIPAMFallsBackWhenNoReservation(t *testing.T) {
+	fallback := newFakeIPAM()
+	r := newReservedIPAM(map[string]string{"node-a": "10.1.1.0/24"}, fallback)
+
+	ipnet, err := r.Allocate("node-b", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fallback.allocated) != 1 {
+		t.Fatalf("expected fallback to allocate for an unreserved node")
+	}
+	if _, ok := fallback.allocated[ipnet.String()]; !ok {
+		t.Fatalf("expected returned CIDR to come from the fallback's allocation")
+	}
+}
+
+func TestReservedIPAMReleaseDoesNotTouchFallbackForReserved(t *testing.T) {
+	fallback := newFakeIPAM()
+	r := newReservedIPAM(map[string]string{"node-a": "10.1.1.0/24"}, fallback)
+
+	ipnet, err := r.Allocate("node-a", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Release(ipnet); err != nil {
+		t.Fatalf("unexpected error releasing reserved CIDR: %v", err)
+	}
+}
+
+func TestLoadReservedNodeCIDRs(t *testing.T) {
+	f, err := ioutil.TempFile("", "reserved-subnets")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	contents := "# comment\nnode-a=10.1.1.0/24\n\nnode-b=10.1.2.0/24\n"
+	if _, err := f.WriteString(contents); err != nil {
+		t.Fatalf("unexpected error writing temp file: %v", err)
+	}
+	f.Close()
+
+	reservations, err := loadReservedNodeCIDRs(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reservations["node-a"] != "10.1.1.0/24" || reservations["node-b"] != "10.1.2.0/24" {
+		t.Fatalf("unexpected reservations: %#v", reservations)
+	}
+}
+
+func TestLoadReservedNodeCIDRsRejectsMalformedLine(t *testing.T) {
+	f, err := ioutil.TempFile("", "reserved-subnets")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString("node-a\n"); err != nil {
+		t.Fatalf("unexpected error writing temp file: %v", err)
+	}
+	f.Close()
+
+	if _, err := loadReservedNodeCIDRs(f.Name()); err == nil {
+		t.Fatalf("expected an error for a malformed reserved-subnet entry")
+	}
+}
+
+// fakeIPAMStateStore is an in-memory ipamStateStore for exercising
+// etcdIPAM's compare-and-swap retry logic without a real etcd client.
+type fakeIPAMStateStore struct {
+	state       string
+	casAttempts int
+	casHook     func(attempt int) // lets a test inject a concurrent writer mid-retry
+}
+
+func (s *fakeIPAMStateStore) GetIPAMState(key string) (string, error) {
+	return s.state, nil
+}
+
+func (s *fakeIPAMStateStore) CompareAndSwapIPAMState(key, prevValue, newValue string) (bool, error) {
+	s.casAttempts++
+	if s.casHook != nil {
+		s.casHook(s.casAttempts)
+	}
+	if s.state != prevValue {
+		return false, nil
+	}
+	s.state = newValue
+	return true, nil
+}
+
+func TestEtcdIPAMUpdateWithCASRetriesOnConflict(t *testing.T) {
+	store := &fakeIPAMStateStore{state: ""}
+	// Simulate a second master sneaking in an extra CIDR before our first
+	// CAS attempt lands, forcing a retry.
+	store.casHook = func(attempt int) {
+		if attempt == 1 {
+			store.state = "10.9.9.0/24"
+		}
+	}
+
+	e := &etcdIPAM{store: store, allocated: map[string]bool{}}
+	if err := e.updateWithCAS(func(allocated map[string]bool) {
+		allocated["10.1.1.0/24"] = true
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if store.casAttempts != 2 {
+		t.Fatalf("expected exactly one retry (2 attempts), got %d", store.casAttempts)
+	}
+	if !e.allocated["10.1.1.0/24"] || !e.allocated["10.9.9.0/24"] {
+		t.Fatalf("expected both the concurrent writer's CIDR and ours to be present, got %#v", e.allocated)
+	}
+}
+
+func TestEtcdIPAMUpdateWithCASGivesUpAfterMaxRetries(t *testing.T) {
+	store := &fakeIPAMStateStore{state: ""}
+	store.casHook = func(attempt int) {
+		// Always mutate the backing state out from under the CAS so it
+		// never succeeds.
+		store.state = fmt.Sprintf("10.%d.0.0/24", attempt)
+	}
+
+	e := &etcdIPAM{store: store, allocated: map[string]bool{}}
+	err := e.updateWithCAS(func(allocated map[string]bool) {
+		allocated["10.1.1.0/24"] = true
+	})
+	if err == nil {
+		t.Fatalf("expected an error after exceeding maxIPAMCASRetries")
+	}
+	if store.casAttempts != maxIPAMCASRetries {
+		t.Fatalf("expected %d attempts, got %d", maxIPAMCASRetries, store.casAttempts)
+	}
+}
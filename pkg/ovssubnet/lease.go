@@ -0,0 +1,121 @@
+package ovssubnet
+
+import (
+	"time"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/pkg/ovssubnet/api"
+)
+
+const (
+	// defaultLeaseTTL is how long a HostSubnet lease is valid before it
+	// must be renewed by the owning node.
+	defaultLeaseTTL = 3 * time.Minute
+	// leaseRenewInterval is how often a node re-asserts its own lease;
+	// comfortably under defaultLeaseTTL so a couple of missed renewals
+	// (a slow etcd write, a restart) don't expire it.
+	leaseRenewInterval = 1 * time.Minute
+	// leaseSweepInterval is how often the master scans for expired leases.
+	leaseSweepInterval = 1 * time.Minute
+)
+
+// createLeasedSubnet wraps registry.CreateSubnet, stamping the new
+// HostSubnet with a fresh lease so the master can reclaim it on expiry
+// instead of holding it forever once written.
+func (oc *OvsController) createLeasedSubnet(nodeName string, subnet *api.Subnet) error {
+	subnet.LeaseExpiration = time.Now().Add(defaultLeaseTTL).Unix()
+	return oc.registry.CreateSubnet(nodeName, subnet)
+}
+
+// renewLease re-asserts this node's own HostSubnet lease, pushing its
+// expiration out another defaultLeaseTTL. It goes through
+// updateSubnetWithCAS rather than a blind CreateSubnet, both because the
+// HostSubnet already exists past the first renewal (CreateSubnet would
+// error on every call after that) and because the master can be
+// concurrently rewriting the same HostSubnet (gateway election, an IP
+// change) and must not have its update clobbered by ours.
+func (oc *OvsController) renewLease() error {
+	_, err := updateSubnetWithCAS(oc.registry, oc.hostName, func(sub *api.Subnet) error {
+		sub.LeaseExpiration = time.Now().Add(defaultLeaseTTL).Unix()
+		return nil
+	})
+	return err
+}
+
+// watchLeaseRenewal periodically renews this node's lease until oc.sig
+// fires.
+func watchLeaseRenewal(oc *OvsController) {
+	ticker := time.NewTicker(leaseRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := oc.renewLease(); err != nil {
+				log.Errorf("Error renewing subnet lease for %s: %v", oc.hostName, err)
+			}
+		case <-oc.sig:
+			return
+		}
+	}
+}
+
+// RevokeSubnet releases nodeName's HostSubnet and hands its CIDRs back to
+// the per-family IPAM backends immediately, without waiting for a
+// node-delete event.
+// Operators can call this directly, and it is also what the master uses
+// to reclaim subnets whose lease has expired.
+func (oc *OvsController) RevokeSubnet(nodeName string) error {
+	return oc.deleteNode(nodeName)
+}
+
+// isLeaseExpired reports whether a lease stamped with expiration (a Unix
+// timestamp, or 0 for "no lease") has expired as of now.
+func isLeaseExpired(expiration int64, now int64) bool {
+	return expiration != 0 && expiration <= now
+}
+
+// expiredLeaseNodes returns the NodeName of every HostSubnet in subnets
+// whose lease has expired as of now. Extracted out of
+// watchLeaseExpiration so the sweep's node-selection logic can be unit
+// tested without a registry.
+func expiredLeaseNodes(subnets []api.Subnet, now int64) []string {
+	var names []string
+	for _, sub := range subnets {
+		if isLeaseExpired(sub.LeaseExpiration, now) {
+			names = append(names, sub.NodeName)
+		}
+	}
+	return names
+}
+
+// watchLeaseExpiration periodically scans every HostSubnet for expired
+// leases and revokes them, treating an expired lease as an implicit
+// node-delete. Critically, this walks oc.registry.GetSubnets() directly
+// rather than joining against the live Node list: a node that rebooted
+// with a new identity, or otherwise disappeared ungracefully, no longer
+// has a Node object at all, and its orphaned HostSubnet would never be
+// found (and its CIDR never reclaimed) if the sweep only visited subnets
+// belonging to still-existing nodes.
+func watchLeaseExpiration(oc *OvsController) {
+	ticker := time.NewTicker(leaseSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			subnets, _, err := oc.registry.GetSubnets()
+			if err != nil {
+				log.Errorf("Error fetching subnets for lease sweep: %v", err)
+				continue
+			}
+			for _, nodeName := range expiredLeaseNodes(subnets, time.Now().Unix()) {
+				log.Warningf("Lease for node %s expired, revoking its subnet", nodeName)
+				if err := oc.RevokeSubnet(nodeName); err != nil {
+					log.Errorf("Error revoking expired subnet for node %s: %v", nodeName, err)
+				}
+			}
+		case <-oc.sig:
+			return
+		}
+	}
+}
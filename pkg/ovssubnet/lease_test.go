@@ -0,0 +1,73 @@
+package ovssubnet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/pkg/ovssubnet/api"
+)
+
+func TestIsLeaseExpired(t *testing.T) {
+	now := int64(1000)
+
+	cases := []struct {
+		name       string
+		expiration int64
+		want       bool
+	}{
+		{"no lease recorded", 0, false},
+		{"still valid", now + 1, false},
+		{"expires exactly now", now, true},
+		{"already expired", now - 1, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isLeaseExpired(c.expiration, now); got != c.want {
+				t.Errorf("isLeaseExpired(%d, %d) = %v, want %v", c.expiration, now, got, c.want)
+			}
+		})
+	}
+}
+
+func TestExpiredLeaseNodesFindsNodesWithNoLiveNodeObject(t *testing.T) {
+	// The whole point of the sweep is to catch HostSubnets belonging to a
+	// node that no longer exists (rebooted with a new identity, or
+	// otherwise vanished); expiredLeaseNodes must not depend on a live
+	// Node list at all, only on what's in the HostSubnet itself.
+	now := int64(1000)
+	subnets := []api.Subnet{
+		{NodeName: "still-live", LeaseExpiration: now + 100},
+		{NodeName: "vanished-node", LeaseExpiration: now - 1},
+	}
+
+	got := expiredLeaseNodes(subnets, now)
+	if !reflect.DeepEqual(got, []string{"vanished-node"}) {
+		t.Fatalf("expiredLeaseNodes = %v, want [vanished-node]", got)
+	}
+}
+
+func TestExpiredLeaseNodesIgnoresSubnetsWithNoLease(t *testing.T) {
+	now := int64(1000)
+	subnets := []api.Subnet{
+		{NodeName: "no-lease", LeaseExpiration: 0},
+	}
+
+	if got := expiredLeaseNodes(subnets, now); len(got) != 0 {
+		t.Fatalf("expected no expired nodes, got %v", got)
+	}
+}
+
+func TestExpiredLeaseNodesReturnsMultipleExpired(t *testing.T) {
+	now := int64(1000)
+	subnets := []api.Subnet{
+		{NodeName: "node-a", LeaseExpiration: now - 1},
+		{NodeName: "node-b", LeaseExpiration: now},
+		{NodeName: "node-c", LeaseExpiration: now + 1},
+	}
+
+	got := expiredLeaseNodes(subnets, now)
+	if !reflect.DeepEqual(got, []string{"node-a", "node-b"}) {
+		t.Fatalf("expiredLeaseNodes = %v, want [node-a node-b]", got)
+	}
+}
@@ -0,0 +1,108 @@
+package ovssubnet
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+const (
+	initialRetryBackoff = 2 * time.Second
+	maxRetryBackoff     = 60 * time.Second
+	maxRetryAttempts    = 15
+	retryLoopInterval   = 2 * time.Second
+)
+
+// retryFunc re-attempts a previously failed reconciliation for the object
+// identified by key, returning nil once it has succeeded.
+type retryFunc func(key string, obj interface{}) error
+
+// retryEntry tracks a single object that failed to reconcile and is
+// waiting to be retried.
+type retryEntry struct {
+	obj            interface{}
+	timeStamp      time.Time
+	backoffSec     time.Duration
+	failedAttempts int
+}
+
+// retryObjs is a small rate-limited work queue. Objects that fail to
+// reconcile are added here instead of being dropped, and are retried with
+// exponential backoff (capped at maxRetryBackoff) until they succeed or
+// exceed maxRetryAttempts, at which point they are given up on and logged.
+type retryObjs struct {
+	lock    sync.Mutex
+	entries map[string]*retryEntry
+	retry   retryFunc
+}
+
+func newRetryObjs(retry retryFunc) *retryObjs {
+	return &retryObjs{
+		entries: make(map[string]*retryEntry),
+		retry:   retry,
+	}
+}
+
+// Add enqueues obj for retry under key. If key is already queued, obj
+// replaces the stale queued object (callers share one key across a
+// sequence of events for the same resource, e.g. successive IP changes
+// for a node, and the retry must act on the latest one) but its backoff
+// is left untouched so a flurry of failures for the same object doesn't
+// reset progress towards maxRetryAttempts.
+func (r *retryObjs) Add(key string, obj interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if entry, exists := r.entries[key]; exists {
+		entry.obj = obj
+		return
+	}
+	r.entries[key] = &retryEntry{
+		obj:        obj,
+		timeStamp:  time.Now(),
+		backoffSec: initialRetryBackoff,
+	}
+}
+
+// Forget drops key from the queue, e.g. because it reconciled
+// successfully through the normal (non-retry) path.
+func (r *retryObjs) Forget(key string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.entries, key)
+}
+
+// process walks the queue once, re-invoking retry() for every entry whose
+// backoff has elapsed.
+func (r *retryObjs) process() {
+	now := time.Now()
+
+	r.lock.Lock()
+	due := make(map[string]*retryEntry)
+	for key, entry := range r.entries {
+		if now.Sub(entry.timeStamp) >= entry.backoffSec {
+			due[key] = entry
+		}
+	}
+	r.lock.Unlock()
+
+	for key, entry := range due {
+		if err := r.retry(key, entry.obj); err != nil {
+			r.lock.Lock()
+			entry.failedAttempts++
+			if entry.failedAttempts >= maxRetryAttempts {
+				log.Errorf("Giving up on %s after %d failed attempts: %v", key, entry.failedAttempts, err)
+				delete(r.entries, key)
+			} else {
+				entry.timeStamp = now
+				entry.backoffSec *= 2
+				if entry.backoffSec > maxRetryBackoff {
+					entry.backoffSec = maxRetryBackoff
+				}
+			}
+			r.lock.Unlock()
+		} else {
+			r.Forget(key)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package ovssubnet
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryObjsAddRefreshesQueuedObject(t *testing.T) {
+	r := newRetryObjs(func(key string, obj interface{}) error { return nil })
+	r.Add("node-1", "first")
+	r.Add("node-1", "second")
+
+	if got := r.entries["node-1"].obj; got != "second" {
+		t.Fatalf("Add did not refresh an already-queued entry: got %v, want %q", got, "second")
+	}
+}
+
+func TestRetryObjsAddDoesNotResetBackoffOnRefresh(t *testing.T) {
+	r := newRetryObjs(func(key string, obj interface{}) error { return nil })
+	r.Add("node-1", "first")
+	r.entries["node-1"].backoffSec = maxRetryBackoff
+	r.entries["node-1"].failedAttempts = 3
+
+	r.Add("node-1", "second")
+
+	entry := r.entries["node-1"]
+	if entry.backoffSec != maxRetryBackoff {
+		t.Fatalf("Add reset backoff on refresh: got %v, want %v", entry.backoffSec, maxRetryBackoff)
+	}
+	if entry.failedAttempts != 3 {
+		t.Fatalf("Add reset failedAttempts on refresh: got %d, want %d", entry.failedAttempts, 3)
+	}
+}
+
+func TestRetryObjsForgetRemovesEntry(t *testing.T) {
+	r := newRetryObjs(func(key string, obj interface{}) error { return nil })
+	r.Add("node-1", "obj")
+	r.Forget("node-1")
+
+	if _, exists := r.entries["node-1"]; exists {
+		t.Fatalf("expected entry to be removed after Forget")
+	}
+}
+
+func TestRetryObjsProcessSucceedsAndForgets(t *testing.T) {
+	calls := 0
+	r := newRetryObjs(func(key string, obj interface{}) error {
+		calls++
+		return nil
+	})
+	r.Add("node-1", "obj")
+	r.entries["node-1"].timeStamp = time.Now().Add(-initialRetryBackoff)
+
+	r.process()
+
+	if calls != 1 {
+		t.Fatalf("expected retry func to be called once, got %d", calls)
+	}
+	if _, exists := r.entries["node-1"]; exists {
+		t.Fatalf("expected entry to be forgotten after a successful retry")
+	}
+}
+
+func TestRetryObjsProcessBacksOffOnFailure(t *testing.T) {
+	r := newRetryObjs(func(key string, obj interface{}) error {
+		return fmt.Errorf("still failing")
+	})
+	r.Add("node-1", "obj")
+	r.entries["node-1"].timeStamp = time.Now().Add(-initialRetryBackoff)
+
+	r.process()
+
+	entry := r.entries["node-1"]
+	if entry == nil {
+		t.Fatalf("expected entry to still be queued after a failed retry")
+	}
+	if entry.failedAttempts != 1 {
+		t.Fatalf("expected failedAttempts to be 1, got %d", entry.failedAttempts)
+	}
+	if entry.backoffSec != initialRetryBackoff*2 {
+		t.Fatalf("expected backoff to double to %v, got %v", initialRetryBackoff*2, entry.backoffSec)
+	}
+}
+
+func TestRetryObjsProcessCapsBackoff(t *testing.T) {
+	r := newRetryObjs(func(key string, obj interface{}) error {
+		return fmt.Errorf("still failing")
+	})
+	r.Add("node-1", "obj")
+	r.entries["node-1"].timeStamp = time.Now().Add(-maxRetryBackoff)
+	r.entries["node-1"].backoffSec = maxRetryBackoff
+
+	r.process()
+
+	if got := r.entries["node-1"].backoffSec; got != maxRetryBackoff {
+		t.Fatalf("expected backoff to stay capped at %v, got %v", maxRetryBackoff, got)
+	}
+}
+
+func TestRetryObjsProcessGivesUpAfterMaxAttempts(t *testing.T) {
+	r := newRetryObjs(func(key string, obj interface{}) error {
+		return fmt.Errorf("still failing")
+	})
+	r.Add("node-1", "obj")
+	entry := r.entries["node-1"]
+	entry.failedAttempts = maxRetryAttempts - 1
+	entry.timeStamp = time.Now().Add(-maxRetryBackoff)
+	entry.backoffSec = maxRetryBackoff
+
+	r.process()
+
+	if _, exists := r.entries["node-1"]; exists {
+		t.Fatalf("expected entry to be dropped after exceeding maxRetryAttempts")
+	}
+}
+
+func TestRetryObjsProcessSkipsEntriesNotYetDue(t *testing.T) {
+	calls := 0
+	r := newRetryObjs(func(key string, obj interface{}) error {
+		calls++
+		return nil
+	})
+	r.Add("node-1", "obj")
+	// timeStamp is "now", well inside the initial backoff window.
+
+	r.process()
+
+	if calls != 0 {
+		t.Fatalf("expected retry func not to be called before backoff elapses, got %d calls", calls)
+	}
+}
@@ -0,0 +1,50 @@
+package ovssubnet
+
+import (
+	"fmt"
+
+	"github.com/openshift/openshift-sdn/pkg/ovssubnet/api"
+)
+
+// maxSubnetCASRetries bounds how many times updateSubnetWithCAS will
+// re-read and retry a mutation against a concurrently-updated HostSubnet
+// before giving up.
+const maxSubnetCASRetries = 5
+
+// subnetStateStore is the slice of osdn.Registry that updateSubnetWithCAS
+// needs. CompareAndSwapSubnet must only apply next if nodeName's
+// HostSubnet still matches prev (ok == false otherwise). A HostSubnet is
+// mutated concurrently by more writers than almost anything else in this
+// package — lease renewal (from the owning node), gateway election, and
+// an IP change (both from the master) — so a plain read-then-write would
+// let one of them silently clobber another's update.
+type subnetStateStore interface {
+	GetSubnet(nodeName string) (*api.Subnet, error)
+	CompareAndSwapSubnet(nodeName string, prev *api.Subnet, next *api.Subnet) (bool, error)
+}
+
+// updateSubnetWithCAS reads nodeName's HostSubnet, applies mutate to a
+// copy of it, and writes the result back to store with compare-and-swap,
+// retrying against the latest value on conflict (i.e. a concurrent
+// writer) up to maxSubnetCASRetries times.
+func updateSubnetWithCAS(store subnetStateStore, nodeName string, mutate func(sub *api.Subnet) error) (*api.Subnet, error) {
+	for attempt := 0; attempt < maxSubnetCASRetries; attempt++ {
+		current, err := store.GetSubnet(nodeName)
+		if err != nil {
+			return nil, err
+		}
+		prev := *current
+		next := *current
+		if err := mutate(&next); err != nil {
+			return nil, err
+		}
+		ok, err := store.CompareAndSwapSubnet(nodeName, &prev, &next)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &next, nil
+		}
+	}
+	return nil, fmt.Errorf("exceeded %d retries updating HostSubnet %s with a concurrent writer", maxSubnetCASRetries, nodeName)
+}
@@ -0,0 +1,134 @@
+package ovssubnet
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/pkg/ovssubnet/api"
+)
+
+// fakeSubnetStore is an in-memory subnetStateStore for exercising
+// updateSubnetWithCAS's create-vs-update and retry-on-conflict behavior
+// without a real etcd-backed registry.
+type fakeSubnetStore struct {
+	subnets     map[string]*api.Subnet
+	casAttempts int
+	casHook     func(attempt int) // lets a test inject a concurrent writer mid-retry
+}
+
+func newFakeSubnetStore(nodeName string, sub *api.Subnet) *fakeSubnetStore {
+	return &fakeSubnetStore{subnets: map[string]*api.Subnet{nodeName: sub}}
+}
+
+func (s *fakeSubnetStore) GetSubnet(nodeName string) (*api.Subnet, error) {
+	sub, ok := s.subnets[nodeName]
+	if !ok {
+		return nil, fmt.Errorf("no HostSubnet for node %s", nodeName)
+	}
+	cp := *sub
+	return &cp, nil
+}
+
+func (s *fakeSubnetStore) CompareAndSwapSubnet(nodeName string, prev *api.Subnet, next *api.Subnet) (bool, error) {
+	s.casAttempts++
+	if s.casHook != nil {
+		s.casHook(s.casAttempts)
+	}
+	current, ok := s.subnets[nodeName]
+	if !ok || !reflect.DeepEqual(*current, *prev) {
+		return false, nil
+	}
+	cp := *next
+	s.subnets[nodeName] = &cp
+	return true, nil
+}
+
+func TestUpdateSubnetWithCASAppliesMutation(t *testing.T) {
+	store := newFakeSubnetStore("node-a", &api.Subnet{NodeName: "node-a", NodeIP: "10.0.0.1"})
+
+	updated, err := updateSubnetWithCAS(store, "node-a", func(sub *api.Subnet) error {
+		sub.NodeIP = "10.0.0.2"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updated.NodeIP != "10.0.0.2" {
+		t.Fatalf("expected NodeIP to be updated, got %s", updated.NodeIP)
+	}
+	if store.subnets["node-a"].NodeIP != "10.0.0.2" {
+		t.Fatalf("expected the stored HostSubnet to reflect the mutation, got %s", store.subnets["node-a"].NodeIP)
+	}
+}
+
+func TestUpdateSubnetWithCASUpdatesExistingRecordRatherThanOverwritingBlind(t *testing.T) {
+	// Regression test: a naive CreateSubnet-on-an-existing-key call would
+	// error out on every write past the first; updateSubnetWithCAS must
+	// instead read-modify-CAS the existing record, leaving fields the
+	// mutation didn't touch (here, GatewayCandidates) untouched.
+	store := newFakeSubnetStore("node-a", &api.Subnet{
+		NodeName:          "node-a",
+		NodeIP:            "10.0.0.1",
+		GatewayCandidates: []string{"node-b", "node-c"},
+	})
+
+	updated, err := updateSubnetWithCAS(store, "node-a", func(sub *api.Subnet) error {
+		sub.GatewayNode = "node-b"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error updating an existing HostSubnet: %v", err)
+	}
+	if updated.GatewayNode != "node-b" {
+		t.Fatalf("expected GatewayNode to be set, got %q", updated.GatewayNode)
+	}
+	if !reflect.DeepEqual(updated.GatewayCandidates, []string{"node-b", "node-c"}) {
+		t.Fatalf("expected untouched fields to survive the update, got %#v", updated.GatewayCandidates)
+	}
+}
+
+func TestUpdateSubnetWithCASRetriesOnConflict(t *testing.T) {
+	store := newFakeSubnetStore("node-a", &api.Subnet{NodeName: "node-a", NodeIP: "10.0.0.1"})
+	// Simulate a concurrent writer (e.g. a lease renewal) landing between
+	// our read and our CAS, forcing a retry against its result.
+	store.casHook = func(attempt int) {
+		if attempt == 1 {
+			store.subnets["node-a"].LeaseExpiration = 12345
+		}
+	}
+
+	updated, err := updateSubnetWithCAS(store, "node-a", func(sub *api.Subnet) error {
+		sub.NodeIP = "10.0.0.2"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.casAttempts != 2 {
+		t.Fatalf("expected exactly one retry (2 attempts), got %d", store.casAttempts)
+	}
+	if updated.NodeIP != "10.0.0.2" || updated.LeaseExpiration != 12345 {
+		t.Fatalf("expected both the concurrent writer's change and ours to be present, got %#v", updated)
+	}
+}
+
+func TestUpdateSubnetWithCASGivesUpAfterMaxRetries(t *testing.T) {
+	store := newFakeSubnetStore("node-a", &api.Subnet{NodeName: "node-a"})
+	store.casHook = func(attempt int) {
+		// Always mutate the backing record out from under the CAS so it
+		// never succeeds.
+		store.subnets["node-a"].LeaseExpiration = int64(attempt)
+	}
+
+	_, err := updateSubnetWithCAS(store, "node-a", func(sub *api.Subnet) error {
+		sub.NodeIP = "10.0.0.2"
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expected an error after exceeding maxSubnetCASRetries")
+	}
+	if store.casAttempts != maxSubnetCASRetries {
+		t.Fatalf("expected %d attempts, got %d", maxSubnetCASRetries, store.casAttempts)
+	}
+}
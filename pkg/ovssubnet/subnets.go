@@ -2,25 +2,36 @@ package ovssubnet
 
 import (
 	"fmt"
-	"net"
 	"time"
 
 	log "github.com/golang/glog"
 
-	"github.com/openshift/openshift-sdn/pkg/netutils"
 	"github.com/openshift/openshift-sdn/pkg/ovssubnet/api"
 	"github.com/openshift/openshift-sdn/plugins/osdn"
 )
 
 func subnetStartMaster(oc *OvsController) error {
-	subrange := make([]string, 0)
-	subnets, _, err := oc.registry.GetSubnets()
-	if err != nil {
-		log.Errorf("Error in initializing/fetching subnets: %v", err)
-		return err
-	}
-	for _, sub := range subnets {
-		subrange = append(subrange, sub.SubnetCIDR)
+	subrangeV4 := make([]string, 0)
+	subrangeV6 := make([]string, 0)
+
+	// The etcd IPAM backend seeds itself from its own persisted state
+	// (see newEtcdIPAM), so scanning every existing HostSubnet here would
+	// just be wasted work on top of a master restart.
+	if oc.ipamBackend != "etcd" {
+		subnets, _, err := oc.registry.GetSubnets()
+		if err != nil {
+			log.Errorf("Error in initializing/fetching subnets: %v", err)
+			return err
+		}
+		for _, sub := range subnets {
+			for _, cidr := range subnetCIDRs(sub) {
+				if isIPv6CIDR(cidr) {
+					subrangeV6 = append(subrangeV6, cidr)
+				} else {
+					subrangeV4 = append(subrangeV4, cidr)
+				}
+			}
+		}
 	}
 
 	cn, err := oc.registry.GetClusterNetworkCIDR()
@@ -35,10 +46,24 @@ func subnetStartMaster(oc *OvsController) error {
 		return err
 	}
 
-	oc.subnetAllocator, err = netutils.NewSubnetAllocator(cn, uint(hsl), subrange)
+	oc.ipamV4, err = newIPAM(oc, cn, uint(hsl), subrangeV4)
+	if err != nil {
+		return err
+	}
+
+	// A v6 cluster network is optional; an empty CIDR means this is a
+	// single-stack (IPv4) cluster and oc.ipamV6 stays nil.
+	cn6, err := oc.registry.GetClusterNetworkCIDRv6()
 	if err != nil {
+		log.Errorf("Error re-fetching IPv6 cluster network CIDR: %v", err)
 		return err
 	}
+	if cn6 != "" {
+		oc.ipamV6, err = newIPAM(oc, cn6, uint(hsl), subrangeV6)
+		if err != nil {
+			return err
+		}
+	}
 
 	getNodes := func(registry *osdn.Registry) (interface{}, string, error) {
 		return registry.GetNodes()
@@ -53,9 +78,82 @@ func subnetStartMaster(oc *OvsController) error {
 		return err
 	}
 
+	oc.nodeRetries = newRetryObjs(oc.retryNode)
+	go watchRetryQueue(oc, oc.nodeRetries)
+	go watchLeaseExpiration(oc)
+	go newGatewayController(oc).watchGateways()
+
 	return nil
 }
 
+// retryNode is the retryFunc for oc.nodeRetries: it re-attempts whatever
+// node reconciliation previously failed.
+func (oc *OvsController) retryNode(key string, obj interface{}) error {
+	switch item := obj.(type) {
+	case nodeAddRetry:
+		return oc.addNode(item.name, item.ip)
+	case nodeDeleteRetry:
+		return oc.deleteNode(item.name)
+	case nodeIPChangeRetry:
+		return oc.updateNodeIP(item.sub, item.name, item.ip)
+	default:
+		log.Errorf("Unexpected retry object for node %s: %#v", key, obj)
+		return nil
+	}
+}
+
+// watchRetryQueue periodically drains queue until oc.sig fires.
+func watchRetryQueue(oc *OvsController, queue *retryObjs) {
+	ticker := time.NewTicker(retryLoopInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			queue.process()
+		case <-oc.sig:
+			return
+		}
+	}
+}
+
+type nodeAddRetry struct {
+	name string
+	ip   string
+}
+
+type nodeDeleteRetry struct {
+	name string
+}
+
+type nodeIPChangeRetry struct {
+	sub  *api.Subnet
+	name string
+	ip   string
+}
+
+// retrySubnet is the retryFunc for oc.subnetRetries: it re-attempts an
+// OVS flow-rule programming call that previously failed.
+func (oc *OvsController) retrySubnet(key string, obj interface{}) error {
+	switch item := obj.(type) {
+	case subnetAddRetry:
+		return oc.flowController.AddOFRules(item.nodeIP, item.cidr, oc.localIP)
+	case subnetDeleteRetry:
+		return oc.flowController.DelOFRules(item.nodeIP, oc.localIP)
+	default:
+		log.Errorf("Unexpected retry object for subnet %s: %#v", key, obj)
+		return nil
+	}
+}
+
+type subnetAddRetry struct {
+	nodeIP string
+	cidr   string
+}
+
+type subnetDeleteRetry struct {
+	nodeIP string
+}
+
 func (oc *OvsController) serveExistingNodes(nodes []api.Node) error {
 	for _, node := range nodes {
 		_, err := oc.registry.GetSubnet(node.Name)
@@ -72,23 +170,28 @@ func (oc *OvsController) serveExistingNodes(nodes []api.Node) error {
 }
 
 func (oc *OvsController) addNode(nodeName string, nodeIP string) error {
-	sn, err := oc.subnetAllocator.GetNetwork()
-	if err != nil {
-		log.Errorf("Error creating network for node %s.", nodeName)
+	if nodeIP == "" || nodeIP == "127.0.0.1" {
+		return fmt.Errorf("Invalid node IP")
+	}
+	if err := oc.validateNodeIPFamily(nodeIP); err != nil {
 		return err
 	}
 
-	if nodeIP == "" || nodeIP == "127.0.0.1" {
-		return fmt.Errorf("Invalid node IP")
+	cidrs, err := oc.allocateNodeNetworks(nodeName)
+	if err != nil {
+		log.Errorf("Error creating network for node %s.", nodeName)
+		return err
 	}
 
 	subnet := &api.Subnet{
-		NodeIP:     nodeIP,
-		SubnetCIDR: sn.String(),
+		NodeName: nodeName,
+		NodeIP:   nodeIP,
 	}
-	err = oc.registry.CreateSubnet(nodeName, subnet)
+	setSubnetCIDRs(subnet, cidrs)
+	err = oc.createLeasedSubnet(nodeName, subnet)
 	if err != nil {
-		log.Errorf("Error writing subnet to etcd for node %s: %v", nodeName, sn)
+		log.Errorf("Error writing subnet to etcd for node %s: %v", nodeName, cidrs)
+		oc.releaseNodeNetworks(cidrs)
 		return err
 	}
 	return nil
@@ -100,13 +203,27 @@ func (oc *OvsController) deleteNode(nodeName string) error {
 		log.Errorf("Error fetching subnet for node %s for delete operation.", nodeName)
 		return err
 	}
-	_, ipnet, err := net.ParseCIDR(sub.SubnetCIDR)
-	if err != nil {
-		log.Errorf("Error parsing subnet for node %s for deletion: %s", nodeName, sub.SubnetCIDR)
+	oc.releaseNodeNetworks(subnetCIDRs(*sub))
+	return oc.registry.DeleteSubnet(nodeName)
+}
+
+// updateNodeIP records a node's new IP against its existing subnet. The
+// write goes through updateSubnetWithCAS instead of a
+// DeleteSubnet+CreateSubnet pair, so a lease renewal or gateway election
+// landing on the same HostSubnet between our read and our write doesn't
+// get silently clobbered.
+func (oc *OvsController) updateNodeIP(sub *api.Subnet, nodeName string, nodeIP string) error {
+	if err := oc.validateNodeIPFamily(nodeIP); err != nil {
 		return err
 	}
-	oc.subnetAllocator.ReleaseNetwork(ipnet)
-	return oc.registry.DeleteSubnet(nodeName)
+	if _, err := updateSubnetWithCAS(oc.registry, nodeName, func(current *api.Subnet) error {
+		current.NodeIP = nodeIP
+		return nil
+	}); err != nil {
+		log.Errorf("Error updating subnet for node %s, old ip %s, new ip %s: %v", nodeName, sub.NodeIP, nodeIP, err)
+		return err
+	}
+	return nil
 }
 
 func subnetStartNode(oc *OvsController) error {
@@ -114,8 +231,12 @@ func subnetStartNode(oc *OvsController) error {
 	if err != nil {
 		return err
 	}
+	if oc.localSubnet == nil {
+		// oc.skipSubnetIfUnreserved was set and no reservation exists for
+		// this node; leave SDN plumbing untouched for it.
+		return nil
+	}
 
-	// Assume we are working with IPv4
 	clusterNetworkCIDR, err := oc.registry.GetClusterNetworkCIDR()
 	if err != nil {
 		log.Errorf("Failed to obtain ClusterNetwork: %v", err)
@@ -126,7 +247,9 @@ func subnetStartNode(oc *OvsController) error {
 		log.Errorf("Failed to obtain ServicesNetwork: %v", err)
 		return err
 	}
-	err = oc.flowController.Setup(oc.localSubnet.SubnetCIDR, clusterNetworkCIDR, servicesNetworkCIDR, oc.nodeMtu)
+	// oc.localSubnet carries one CIDR per address family; flowController
+	// programs OVS rules for each.
+	err = oc.flowController.Setup(subnetCIDRs(*oc.localSubnet), clusterNetworkCIDR, servicesNetworkCIDR, oc.nodeMtu)
 	if err != nil {
 		return err
 	}
@@ -138,11 +261,21 @@ func subnetStartNode(oc *OvsController) error {
 	if err != nil {
 		return err
 	}
+	oc.subnetRetries = newRetryObjs(oc.retrySubnet)
+	go watchRetryQueue(oc, oc.subnetRetries)
+
 	subnets := result.([]api.Subnet)
 	for _, s := range subnets {
-		oc.flowController.AddOFRules(s.NodeIP, s.SubnetCIDR, oc.localIP)
+		for _, cidr := range subnetCIDRs(s) {
+			if err := oc.flowController.AddOFRules(s.NodeIP, cidr, oc.localIP); err != nil {
+				log.Errorf("Error adding OF rules for node %s subnet %s, will retry: %v", s.NodeIP, cidr, err)
+				oc.subnetRetries.Add(s.NodeIP+"/"+cidr, subnetAddRetry{nodeIP: s.NodeIP, cidr: cidr})
+			}
+		}
 	}
 
+	go watchLeaseRenewal(oc)
+
 	return nil
 }
 
@@ -164,6 +297,10 @@ func (oc *OvsController) initSelfSubnet() error {
 		time.Sleep(retryInterval)
 	}
 	if err != nil {
+		if oc.skipSubnetIfUnreserved {
+			log.Warningf("No pre-existing subnet reservation found for %s, skipping subnet acquisition", oc.hostName)
+			return nil
+		}
 		return fmt.Errorf("Failed to get subnet for this host: %s, error: %v", oc.hostName, err)
 	}
 	oc.localSubnet = subnet
@@ -182,26 +319,25 @@ func watchNodes(oc *OvsController, ready chan<- bool, start <-chan string) {
 				sub, err := oc.registry.GetSubnet(ev.Node.Name)
 				if err != nil {
 					// subnet does not exist already
-					oc.addNode(ev.Node.Name, ev.Node.IP)
+					if err := oc.addNode(ev.Node.Name, ev.Node.IP); err != nil {
+						log.Errorf("Error creating subnet for node %s, will retry: %v", ev.Node.Name, err)
+						oc.nodeRetries.Add(ev.Node.Name, nodeAddRetry{name: ev.Node.Name, ip: ev.Node.IP})
+					}
 				} else {
 					// Current node IP is obtained from event, ev.NodeIP to
 					// avoid cached/stale IP lookup by net.LookupIP()
 					if sub.NodeIP != ev.Node.IP {
-						err = oc.registry.DeleteSubnet(ev.Node.Name)
-						if err != nil {
-							log.Errorf("Error deleting subnet for node %s, old ip %s", ev.Node.Name, sub.NodeIP)
-							continue
-						}
-						sub.NodeIP = ev.Node.IP
-						err = oc.registry.CreateSubnet(ev.Node.Name, sub)
-						if err != nil {
-							log.Errorf("Error creating subnet for node %s, ip %s", ev.Node.Name, sub.NodeIP)
+						if err := oc.updateNodeIP(sub, ev.Node.Name, ev.Node.IP); err != nil {
+							oc.nodeRetries.Add(ev.Node.Name, nodeIPChangeRetry{sub: sub, name: ev.Node.Name, ip: ev.Node.IP})
 							continue
 						}
 					}
 				}
 			case api.Deleted:
-				oc.deleteNode(ev.Node.Name)
+				if err := oc.deleteNode(ev.Node.Name); err != nil {
+					log.Errorf("Error deleting subnet for node %s, will retry: %v", ev.Node.Name, err)
+					oc.nodeRetries.Add(ev.Node.Name, nodeDeleteRetry{name: ev.Node.Name})
+				}
 			}
 		case <-oc.sig:
 			log.Error("Signal received. Stopping watching of nodes.")
@@ -220,11 +356,20 @@ func watchSubnets(oc *OvsController, ready chan<- bool, start <-chan string) {
 		case ev := <-clusterEvent:
 			switch ev.Type {
 			case api.Added:
-				// add openflow rules
-				oc.flowController.AddOFRules(ev.Subnet.NodeIP, ev.Subnet.SubnetCIDR, oc.localIP)
+				// add openflow rules, one per address family
+				for _, cidr := range subnetCIDRs(*ev.Subnet) {
+					if err := oc.flowController.AddOFRules(ev.Subnet.NodeIP, cidr, oc.localIP); err != nil {
+						log.Errorf("Error adding OF rules for node %s subnet %s, will retry: %v", ev.Subnet.NodeIP, cidr, err)
+						oc.subnetRetries.Add(ev.Subnet.NodeIP+"/"+cidr, subnetAddRetry{nodeIP: ev.Subnet.NodeIP, cidr: cidr})
+					}
+				}
+				oc.applyGatewayElection(ev.Subnet)
 			case api.Deleted:
 				// delete openflow rules meant for the node
-				oc.flowController.DelOFRules(ev.Subnet.NodeIP, oc.localIP)
+				if err := oc.flowController.DelOFRules(ev.Subnet.NodeIP, oc.localIP); err != nil {
+					log.Errorf("Error deleting OF rules for node %s, will retry: %v", ev.Subnet.NodeIP, err)
+					oc.subnetRetries.Add(ev.Subnet.NodeIP, subnetDeleteRetry{nodeIP: ev.Subnet.NodeIP})
+				}
 			}
 		case <-oc.sig:
 			stop <- true